@@ -0,0 +1,119 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+)
+
+// Codec is a pluggable per-frame compression codec for ChunkedCompactWriter
+// and ChunkedCompactReader. Each codec is identified on the wire by a single
+// byte, written into every frame, so a reader can dispatch without any
+// out-of-band negotiation.
+type Codec interface {
+	// ID is the byte written into a frame's header to identify this codec to
+	// a reader. It must be unique among the codecs used on a given stream.
+	ID() uint8
+
+	// Encode appends the encoded form of src to dst and returns the extended
+	// buffer, in the manner of append.
+	Encode(dst, src []byte) []byte
+
+	// Decode appends the decoded form of src to dst and returns the extended
+	// buffer. maxDecodedSize bounds the decoded size the implementation may
+	// produce or allocate towards; if src decodes (or claims to decode) to
+	// more than maxDecodedSize bytes, Decode must fail rather than honor it.
+	// A maxDecodedSize of 0 means no limit.
+	Decode(dst, src []byte, maxDecodedSize int) ([]byte, error)
+}
+
+const (
+	codecIDNone   uint8 = 0
+	codecIDSnappy uint8 = 1
+	codecIDZstd   uint8 = 2
+)
+
+// noneCodec is the identity Codec: frames are stored uncompressed.
+type noneCodec struct{}
+
+// CodecNone is the default Codec: it performs no compression.
+var CodecNone Codec = noneCodec{}
+
+func (noneCodec) ID() uint8 { return codecIDNone }
+
+func (noneCodec) Encode(dst, src []byte) []byte {
+	return append(dst, src...)
+}
+
+func (noneCodec) Decode(dst, src []byte, maxDecodedSize int) ([]byte, error) {
+	if maxDecodedSize > 0 && len(src) > maxDecodedSize {
+		return nil, fmt.Errorf("chunked compact: decoded size %d exceeds max decoded frame size %d", len(src), maxDecodedSize)
+	}
+	return append(dst, src...), nil
+}
+
+// snappyCodec compresses frames with snappy block compression.
+type snappyCodec struct{}
+
+// CodecSnappy compresses frames with snappy block compression.
+var CodecSnappy Codec = snappyCodec{}
+
+func (snappyCodec) ID() uint8 { return codecIDSnappy }
+
+func (snappyCodec) Encode(dst, src []byte) []byte {
+	// snappy.Encode writes from the start of the slice it is given rather
+	// than appending to it, so hand it the room past len(dst).
+	n := len(dst)
+	if need := snappy.MaxEncodedLen(len(src)); cap(dst)-n < need {
+		dst = append(dst[:n:n], make([]byte, need)...)[:n]
+	}
+	encoded := snappy.Encode(dst[n:cap(dst)], src)
+	return dst[:n+len(encoded)]
+}
+
+func (snappyCodec) Decode(dst, src []byte, maxDecodedSize int) ([]byte, error) {
+	// snappy's block format carries the decoded length in-band, so the size
+	// can be checked before the (potentially huge) allocation that decoding
+	// needs to hold it.
+	dLen, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w", err)
+	}
+	if maxDecodedSize > 0 && dLen > maxDecodedSize {
+		return nil, fmt.Errorf("chunked compact: decoded size %d exceeds max decoded frame size %d", dLen, maxDecodedSize)
+	}
+	// snappy.Decode writes from the start of the slice it is given rather
+	// than appending to it, so hand it the room past len(dst).
+	n := len(dst)
+	if cap(dst)-n < dLen {
+		dst = append(dst[:n:n], make([]byte, dLen)...)[:n]
+	}
+	decoded, err := snappy.Decode(dst[n:n+dLen], src)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w", err)
+	}
+	return dst[:n+len(decoded)], nil
+}
+
+// defaultCodecs returns the set of codecs a ChunkedCompactReader understands
+// without the caller registering anything extra.
+func defaultCodecs() map[uint8]Codec {
+	return map[uint8]Codec{
+		codecIDNone:   CodecNone,
+		codecIDSnappy: CodecSnappy,
+		codecIDZstd:   CodecZstd,
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdEncoder and zstdDecoder are shared across every frame; both are safe
+// for concurrent use, so there's no need to pay for a fresh decoder (and its
+// worker goroutines) per frame on what is meant to be a streaming hot path.
+// WithSingleSegment is set on the encoder so every frame carries its
+// Frame_Content_Size, which zstdCodec.Decode relies on to bound the decoded
+// size without decoding first; EncodeAll already requires the whole frame's
+// content in memory, so this costs nothing extra here.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithSingleSegment(true))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// zstdCodec compresses frames with zstd. Callers who want a dictionary
+// trained on their label set (the common win for remote-read payloads) should
+// supply their own Codec built on zstd.WithEncoderDict/WithDecoderDicts
+// rather than use this one.
+type zstdCodec struct{}
+
+// CodecZstd compresses frames with zstd, using its default (dictionary-less)
+// settings.
+var CodecZstd Codec = zstdCodec{}
+
+func (zstdCodec) ID() uint8 { return codecIDZstd }
+
+func (zstdCodec) Encode(dst, src []byte) []byte {
+	return zstdEncoder.EncodeAll(src, dst)
+}
+
+func (zstdCodec) Decode(dst, src []byte, maxDecodedSize int) ([]byte, error) {
+	if maxDecodedSize > 0 {
+		// The frame header carries the uncompressed size up front, so it can
+		// be checked against maxDecodedSize before DecodeAll does the actual
+		// (potentially large) allocation to hold it.
+		var hdr zstd.Header
+		if err := hdr.Decode(src); err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		if !hdr.HasFCS {
+			return nil, fmt.Errorf("chunked compact: zstd frame has no content size, can't bound decoded size")
+		}
+		if hdr.FrameContentSize > uint64(maxDecodedSize) {
+			return nil, fmt.Errorf("chunked compact: decoded size %d exceeds max decoded frame size %d", hdr.FrameContentSize, maxDecodedSize)
+		}
+	}
+
+	decoded, err := zstdDecoder.DecodeAll(src, dst)
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	return decoded, nil
+}
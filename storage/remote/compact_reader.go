@@ -0,0 +1,146 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// ErrChunkedCompactCRCMismatch is returned by ChunkedCompactReader.Next when a
+// frame's computed CRC32 does not match the one recorded in its header. It is
+// distinguished from other errors so that callers can decide whether to abort
+// the stream or attempt to resync on a later frame boundary.
+var ErrChunkedCompactCRCMismatch = errors.New("chunked compact reader: CRC32 mismatch")
+
+// DefaultChunkedCompactReaderMaxFrameSize is the MaxFrameSize used by
+// NewChunkedCompactReader when the caller leaves it at the zero value.
+const DefaultChunkedCompactReaderMaxFrameSize = 64 * 1024 * 1024 // 64MB
+
+// DefaultChunkedCompactReaderMaxDecodedFrameSize is the MaxDecodedFrameSize
+// used by NewChunkedCompactReader when the caller leaves it at the zero
+// value.
+const DefaultChunkedCompactReaderMaxDecodedFrameSize = 64 * 1024 * 1024 // 64MB
+
+// ChunkedCompactReader reads frames written by a ChunkedCompactWriter:
+// uvarint(len) | uint8(codec) | uint32(crc32 castagnoli over the encoded
+// payload) | encoded payload.
+type ChunkedCompactReader struct {
+	r         *bufio.Reader
+	buf       []byte
+	encodeBuf []byte
+
+	crc32  hash.Hash32
+	codecs map[uint8]Codec
+
+	// MaxFrameSize bounds the encoded payload size accepted for a single
+	// frame, so a hostile or buggy peer can't force an unbounded allocation.
+	// Frames advertising a larger size are rejected before their payload is
+	// read. If zero, DefaultChunkedCompactReaderMaxFrameSize applies.
+	MaxFrameSize int
+
+	// MaxDecodedFrameSize bounds the decoded payload size a codec may
+	// produce for a single frame. This is distinct from MaxFrameSize: a
+	// compressed frame can be tiny on the wire yet decode to something much
+	// larger, so codecs are required to check src against this bound (e.g.
+	// via its in-band decoded length) before allocating to hold it. If zero,
+	// DefaultChunkedCompactReaderMaxDecodedFrameSize applies.
+	MaxDecodedFrameSize int
+}
+
+// NewChunkedCompactReader constructs a ChunkedCompactReader reading frames
+// from r, reusing readBuf as the initial output buffer. It understands the
+// built-in codecs (none, snappy, zstd); use NewChunkedCompactReaderWithCodecs
+// to add others, e.g. a dictionary-trained zstd codec.
+func NewChunkedCompactReader(readBuf []byte, r io.Reader) *ChunkedCompactReader {
+	return NewChunkedCompactReaderWithCodecs(readBuf, r)
+}
+
+// NewChunkedCompactReaderWithCodecs constructs a ChunkedCompactReader that
+// additionally dispatches to codecs by their Codec.ID, on top of the
+// built-in none, snappy and zstd codecs.
+func NewChunkedCompactReaderWithCodecs(readBuf []byte, r io.Reader, codecs ...Codec) *ChunkedCompactReader {
+	cr := &ChunkedCompactReader{r: bufio.NewReader(r), buf: readBuf, crc32: crc32.New(castagnoliTable), codecs: defaultCodecs()}
+	for _, c := range codecs {
+		cr.codecs[c.ID()] = c
+	}
+	return cr
+}
+
+// Next returns the decoded payload of the next frame. The returned slice is
+// only valid until the next call to Next. It returns io.EOF once the stream
+// ends on a frame boundary.
+func (r *ChunkedCompactReader) Next() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+
+	maxFrameSize := r.MaxFrameSize
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultChunkedCompactReaderMaxFrameSize
+	}
+	if size > uint64(maxFrameSize) {
+		return nil, fmt.Errorf("chunked compact reader: frame size %d exceeds max frame size %d", size, maxFrameSize)
+	}
+
+	var codecBuf [1]byte
+	if _, err := io.ReadFull(r.r, codecBuf[:]); err != nil {
+		return nil, fmt.Errorf("reading codec: %w", err)
+	}
+	codec, ok := r.codecs[codecBuf[0]]
+	if !ok {
+		return nil, fmt.Errorf("chunked compact reader: unknown codec id %d", codecBuf[0])
+	}
+
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(r.r, crcBuf[:]); err != nil {
+		return nil, fmt.Errorf("reading CRC32: %w", err)
+	}
+	wantCRC := binary.BigEndian.Uint32(crcBuf[:])
+
+	if cap(r.encodeBuf) < int(size) {
+		r.encodeBuf = make([]byte, size)
+	}
+	encoded := r.encodeBuf[:size]
+	if _, err := io.ReadFull(r.r, encoded); err != nil {
+		return nil, fmt.Errorf("reading payload: %w", err)
+	}
+
+	r.crc32.Reset()
+	if _, err := r.crc32.Write(encoded); err != nil {
+		return nil, err
+	}
+	if r.crc32.Sum32() != wantCRC {
+		return nil, ErrChunkedCompactCRCMismatch
+	}
+
+	maxDecodedFrameSize := r.MaxDecodedFrameSize
+	if maxDecodedFrameSize == 0 {
+		maxDecodedFrameSize = DefaultChunkedCompactReaderMaxDecodedFrameSize
+	}
+
+	buf, err := codec.Decode(r.buf[:0], encoded, maxDecodedFrameSize)
+	if err != nil {
+		return nil, fmt.Errorf("decoding payload: %w", err)
+	}
+	r.buf = buf
+
+	return buf, nil
+}
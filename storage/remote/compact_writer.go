@@ -20,22 +20,78 @@ import (
 	"hash/crc32"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type ChunkedCompactWriter struct {
 	writer  io.Writer
 	flusher http.Flusher
 
-	crc32    hash.Hash32
-	writeBuf []byte
+	codec Codec
+
+	mu        sync.Mutex
+	crc32     hash.Hash32
+	writeBuf  []byte
+	encodeBuf []byte
+
+	flushInterval time.Duration
+	flushTimer    *time.Timer
 }
 
-// NewChunkedCompactWriter constructs a ChunkedCompactWriter.
+// NewChunkedCompactWriter constructs a ChunkedCompactWriter that writes each
+// frame uncompressed.
 func NewChunkedCompactWriter(writeBuf []byte, w io.Writer, f http.Flusher) *ChunkedCompactWriter {
-	return &ChunkedCompactWriter{writeBuf: writeBuf[:0], writer: w, flusher: f, crc32: crc32.New(castagnoliTable)}
+	return NewChunkedCompactWriterWithCodec(writeBuf, w, f, CodecNone)
+}
+
+// NewChunkedCompactWriterWithCodec constructs a ChunkedCompactWriter that
+// compresses each frame's payload with codec before writing it, so callers
+// can negotiate e.g. snappy or a dictionary-trained zstd codec for
+// label-heavy payloads.
+func NewChunkedCompactWriterWithCodec(writeBuf []byte, w io.Writer, f http.Flusher, codec Codec) *ChunkedCompactWriter {
+	return &ChunkedCompactWriter{writeBuf: writeBuf[:0], writer: w, flusher: f, codec: codec, crc32: crc32.New(castagnoliTable)}
+}
+
+// NewChunkedCompactWriterWithFlushInterval constructs a ChunkedCompactWriter
+// that, in addition to flushing whenever the write buffer would overflow on
+// the next Write, forces a flush of any buffered frames after d has elapsed
+// since the last Write. This bounds end-to-end latency for low-rate
+// producers, which would otherwise leave a trailing frame sitting in the
+// buffer until enough data arrived to fill it. Call Stop, or Close, to tear
+// down the timer once the writer is no longer needed.
+func NewChunkedCompactWriterWithFlushInterval(writeBuf []byte, w io.Writer, f http.Flusher, d time.Duration) *ChunkedCompactWriter {
+	cw := NewChunkedCompactWriter(writeBuf, w, f)
+	cw.flushInterval = d
+	cw.flushTimer = time.AfterFunc(d, cw.timerFlush)
+	return cw
+}
+
+func (w *ChunkedCompactWriter) timerFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.flushLocked()
+}
+
+// Stop disables the flush timer configured via
+// NewChunkedCompactWriterWithFlushInterval. It is a no-op on a writer
+// without a flush timer, and safe to call more than once.
+func (w *ChunkedCompactWriter) Stop() {
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+	}
 }
 
 func (w *ChunkedCompactWriter) Close() error {
+	w.Stop()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked()
+}
+
+// flushLocked writes out any buffered frames. w.mu must be held.
+func (w *ChunkedCompactWriter) flushLocked() error {
 	if len(w.writeBuf) == 0 {
 		return nil
 	}
@@ -49,6 +105,7 @@ func (w *ChunkedCompactWriter) Close() error {
 	}
 
 	w.flusher.Flush()
+	w.writeBuf = w.writeBuf[:0]
 	return nil
 }
 
@@ -57,33 +114,38 @@ func (w *ChunkedCompactWriter) Write(b []byte) (int, error) {
 		return 0, nil
 	}
 
-	// len(b) + crc32 + binary.MaxVarintLen64
-	requiredSpaceBytes := len(b) + 32/8 + binary.MaxVarintLen64
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.encodeBuf = w.codec.Encode(w.encodeBuf[:0], b)
+	encoded := w.encodeBuf
+
+	// len(encoded) + codec + crc32 + binary.MaxVarintLen64
+	requiredSpaceBytes := len(encoded) + 1 + 32/8 + binary.MaxVarintLen64
 
 	leftSpaceBytes := cap(w.writeBuf) - len(w.writeBuf)
 
 	if len(w.writeBuf) > 0 && leftSpaceBytes < requiredSpaceBytes {
-		n, err := w.writer.Write(w.writeBuf)
-		if err != nil {
-			return n, err
-		}
-		if n != len(w.writeBuf) {
-			return n, fmt.Errorf("short write: wrote %v but buf is %v", n, len(w.writeBuf))
+		if err := w.flushLocked(); err != nil {
+			return 0, err
 		}
-		w.flusher.Flush()
-		w.writeBuf = w.writeBuf[:0]
 	}
 
 	var buf [binary.MaxVarintLen64]byte
-	v := binary.PutUvarint(buf[:], uint64(len(b)))
+	v := binary.PutUvarint(buf[:], uint64(len(encoded)))
 	w.writeBuf = append(w.writeBuf, buf[:v]...)
+	w.writeBuf = append(w.writeBuf, w.codec.ID())
 
 	w.crc32.Reset()
-	if _, err := w.crc32.Write(b); err != nil {
+	if _, err := w.crc32.Write(encoded); err != nil {
 		return 0, err
 	}
 	w.writeBuf = binary.BigEndian.AppendUint32(w.writeBuf, w.crc32.Sum32())
-	w.writeBuf = append(w.writeBuf, b...)
+	w.writeBuf = append(w.writeBuf, encoded...)
+
+	if w.flushTimer != nil {
+		w.flushTimer.Reset(w.flushInterval)
+	}
 
 	return len(b), nil
 }
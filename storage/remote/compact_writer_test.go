@@ -0,0 +1,110 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer guarded by a mutex, so that a test can safely
+// read it from one goroutine while ChunkedCompactWriter's flush timer writes
+// to it from another.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestChunkedCompactWriterFlushesOnInactivity(t *testing.T) {
+	var buf syncBuffer
+	w := NewChunkedCompactWriterWithFlushInterval(nil, &buf, fakeFlusher{}, 10*time.Millisecond)
+	defer w.Stop()
+
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("buffer was flushed eagerly: %d bytes written before the flush interval elapsed", buf.Len())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if buf.Len() == 0 {
+		t.Fatalf("flush timer did not flush the buffered frame after the flush interval elapsed")
+	}
+}
+
+func TestChunkedCompactWriterConcurrentWriteAndFlushTimer(t *testing.T) {
+	var buf syncBuffer
+	w := NewChunkedCompactWriterWithFlushInterval(nil, &buf, fakeFlusher{}, time.Millisecond)
+
+	const n = 200
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := w.Write([]byte(fmt.Sprintf("payload-%d", i))); err != nil {
+				t.Errorf("Write(%d): %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r := NewChunkedCompactReader(nil, bytes.NewReader(buf.Bytes()))
+	got := make(map[string]bool, n)
+	for {
+		frame, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got[string(frame)] = true
+	}
+
+	for i := 0; i < n; i++ {
+		want := fmt.Sprintf("payload-%d", i)
+		if !got[want] {
+			t.Errorf("payload %q is missing from the output stream", want)
+		}
+	}
+}